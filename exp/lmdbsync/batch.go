@@ -0,0 +1,283 @@
+package lmdbsync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+// ErrBatchClosed is returned by BatchedEnv.Update/UpdateLocked/RunTxn once
+// Close has been called, and for any operations still queued when Close is
+// called.
+var ErrBatchClosed = errors.New("lmdbsync: batch closed")
+
+// DefaultMaxBatchSize is the default BatchOptions.MaxBatchSize used by
+// NewBatchedEnv.
+var DefaultMaxBatchSize = 1000
+
+// DefaultMaxBatchDelay is the default BatchOptions.MaxBatchDelay used by
+// NewBatchedEnv.
+var DefaultMaxBatchDelay = 10 * time.Millisecond
+
+// BatchOptions configures a BatchedEnv returned by NewBatchedEnv.
+type BatchOptions struct {
+	// MaxBatchSize is the maximum number of queued operations committed in
+	// a single underlying write transaction.  A value <= 0 uses
+	// DefaultMaxBatchSize.
+	MaxBatchSize int
+
+	// MaxBatchDelay is the maximum amount of time an operation waits for
+	// other operations to join its batch before the batch is committed.  A
+	// value <= 0 uses DefaultMaxBatchDelay.
+	MaxBatchDelay time.Duration
+}
+
+type batchCall struct {
+	op   lmdb.TxnOp
+	errc chan error
+}
+
+// BatchedEnv wraps an Env and funnels the write transactions submitted
+// through Update, UpdateLocked, RunTxn (with non-readonly flags), and their
+// Context counterparts to a single long-lived goroutine that commits many
+// queued operations inside one underlying LMDB write transaction.  This
+// amortizes the fsync cost of a write transaction across all of the
+// goroutines calling into it, the same tradeoff bbolt's Batch method makes,
+// and gives Go callers an actor/mailbox style entrypoint similar to
+// golmdb's write goroutine.
+//
+// Read transactions are unaffected; View and ViewContext continue to run
+// concurrently through the embedded Env's txnlock.RLock path.
+type BatchedEnv struct {
+	*Env
+
+	opts BatchOptions
+
+	mu    sync.Mutex
+	queue []*batchCall
+	wake  chan struct{}
+	done  chan struct{}
+
+	// updateFunc, when set, replaces b.Env.Update as the function commit
+	// uses to run a batch's write transaction.  It exists so tests can
+	// exercise commit's partial-failure/re-run logic against a fake
+	// transaction runner instead of a real lmdb.Env.
+	updateFunc func(lmdb.TxnOp) error
+}
+
+// NewBatchedEnv returns a BatchedEnv wrapping env.  The returned value has
+// the same methods as Env, but it is a distinct type: code that takes an
+// *Env must be given b.Env, and calls made through it bypass batching.  Call
+// Close when the BatchedEnv is no longer needed to stop its
+// worker goroutine.
+func NewBatchedEnv(env *Env, opts BatchOptions) *BatchedEnv {
+	if opts.MaxBatchSize <= 0 {
+		opts.MaxBatchSize = DefaultMaxBatchSize
+	}
+	if opts.MaxBatchDelay <= 0 {
+		opts.MaxBatchDelay = DefaultMaxBatchDelay
+	}
+
+	b := &BatchedEnv{
+		Env:  env,
+		opts: opts,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go b.worker()
+	return b
+}
+
+// Close stops the batch worker goroutine.  Any operations still queued are
+// failed with ErrBatchClosed; operations submitted after Close returns also
+// fail with ErrBatchClosed.  Close does not close the embedded Env.
+func (b *BatchedEnv) Close() error {
+	b.mu.Lock()
+	select {
+	case <-b.done:
+		b.mu.Unlock()
+		return nil
+	default:
+	}
+	close(b.done)
+	batch := b.queue
+	b.queue = nil
+	b.mu.Unlock()
+
+	for _, call := range batch {
+		call.errc <- ErrBatchClosed
+	}
+	return nil
+}
+
+// Update queues op to run as part of the next committed batch and blocks
+// until that batch has committed (or failed).
+func (b *BatchedEnv) Update(op lmdb.TxnOp) error {
+	return b.submit(op)
+}
+
+// UpdateLocked queues op like Update.  Batching already serializes all
+// write transactions through the worker goroutine so there is no
+// additional locking to perform.
+func (b *BatchedEnv) UpdateLocked(op lmdb.TxnOp) error {
+	return b.submit(op)
+}
+
+// RunTxn queues op like Update when flags does not contain lmdb.Readonly.
+// Readonly calls are proxied directly to the embedded Env so they continue
+// to run concurrently with the batch worker.
+func (b *BatchedEnv) RunTxn(flags uint, op lmdb.TxnOp) error {
+	if flags&lmdb.Readonly != 0 {
+		return b.Env.RunTxn(flags, op)
+	}
+	return b.submit(op)
+}
+
+// UpdateContext queues op like Update, through the same batch worker, but
+// returns ctx.Err() if ctx is done before op's batch commits.  op remains
+// queued and still runs as part of that batch; only the caller's wait is
+// abandoned, so op must not assume its result reaches anyone.
+func (b *BatchedEnv) UpdateContext(ctx context.Context, op lmdb.TxnOp) error {
+	return b.submitContext(ctx, op)
+}
+
+// UpdateLockedContext queues op like UpdateContext.
+func (b *BatchedEnv) UpdateLockedContext(ctx context.Context, op lmdb.TxnOp) error {
+	return b.submitContext(ctx, op)
+}
+
+// RunTxnContext queues op like UpdateContext when flags does not contain
+// lmdb.Readonly.  Readonly calls are proxied directly to the embedded Env.
+func (b *BatchedEnv) RunTxnContext(ctx context.Context, flags uint, op lmdb.TxnOp) error {
+	if flags&lmdb.Readonly != 0 {
+		return b.Env.RunTxnContext(ctx, flags, op)
+	}
+	return b.submitContext(ctx, op)
+}
+
+func (b *BatchedEnv) submit(op lmdb.TxnOp) error {
+	return b.submitContext(context.Background(), op)
+}
+
+func (b *BatchedEnv) submitContext(ctx context.Context, op lmdb.TxnOp) error {
+	call := &batchCall{op: op, errc: make(chan error, 1)}
+
+	b.mu.Lock()
+	select {
+	case <-b.done:
+		b.mu.Unlock()
+		return ErrBatchClosed
+	default:
+	}
+	b.queue = append(b.queue, call)
+	full := len(b.queue) >= b.opts.MaxBatchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	select {
+	case err := <-call.errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// worker is the actor goroutine: it owns the single write transaction used
+// to commit every batch and never runs concurrently with itself.
+func (b *BatchedEnv) worker() {
+	timer := time.NewTimer(b.opts.MaxBatchDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.wake:
+		case <-timer.C:
+		}
+
+		b.mu.Lock()
+		batch := b.queue
+		b.queue = nil
+		if len(batch) > b.opts.MaxBatchSize {
+			b.queue = batch[b.opts.MaxBatchSize:]
+			batch = batch[:b.opts.MaxBatchSize]
+		}
+		full := len(b.queue) >= b.opts.MaxBatchSize
+		b.mu.Unlock()
+
+		if len(batch) > 0 {
+			b.commit(batch)
+		}
+
+		if full {
+			select {
+			case b.wake <- struct{}{}:
+			default:
+			}
+		}
+
+		timer.Reset(b.opts.MaxBatchDelay)
+	}
+}
+
+// commit runs batch as a single write transaction.  If every op succeeds
+// they all share the one commit.  If an op fails, matching bbolt's Batch
+// semantics, that op's error is returned only to its own submitter and the
+// surviving ops are re-run one at a time in fresh transactions so a single
+// bad op cannot sink its batch-mates.
+func (b *BatchedEnv) commit(batch []*batchCall) {
+	failIdx := -1
+	err := b.update(func(txn *lmdb.Txn) error {
+		failIdx = -1
+		for i, call := range batch {
+			if err := call.op(txn); err != nil {
+				failIdx = i
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		for _, call := range batch {
+			call.errc <- nil
+		}
+		return
+	}
+
+	if failIdx < 0 {
+		// The transaction failed for a reason unrelated to any op, e.g. a
+		// resize retry was exhausted.  Every caller sees the same error.
+		for _, call := range batch {
+			call.errc <- err
+		}
+		return
+	}
+
+	batch[failIdx].errc <- err
+	for i, call := range batch {
+		if i == failIdx {
+			continue
+		}
+		call.errc <- b.update(call.op)
+	}
+}
+
+// update runs op as a write transaction, using updateFunc in place of
+// b.Env.Update when set.
+func (b *BatchedEnv) update(op lmdb.TxnOp) error {
+	if b.updateFunc != nil {
+		return b.updateFunc(op)
+	}
+	return b.Env.Update(op)
+}
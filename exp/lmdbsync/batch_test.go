@@ -0,0 +1,126 @@
+package lmdbsync
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+func newTestBatchedEnv(updateFunc func(lmdb.TxnOp) error) *BatchedEnv {
+	return &BatchedEnv{
+		Env:        &Env{},
+		updateFunc: updateFunc,
+	}
+}
+
+func TestBatchedEnvCommitSuccess(t *testing.T) {
+	var ran []int
+	b := newTestBatchedEnv(func(op lmdb.TxnOp) error {
+		return op(nil)
+	})
+
+	calls := make([]*batchCall, 3)
+	for i := range calls {
+		i := i
+		calls[i] = &batchCall{
+			op:   func(txn *lmdb.Txn) error { ran = append(ran, i); return nil },
+			errc: make(chan error, 1),
+		}
+	}
+
+	b.commit(calls)
+
+	for i, call := range calls {
+		if err := <-call.errc; err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i, err)
+		}
+	}
+	if len(ran) != 3 {
+		t.Fatalf("expected all 3 ops to run in the shared transaction, got %v", ran)
+	}
+}
+
+func TestBatchedEnvCommitPartialFailure(t *testing.T) {
+	errBad := errors.New("bad op")
+
+	var commits int
+	b := newTestBatchedEnv(func(op lmdb.TxnOp) error {
+		commits++
+		return op(nil)
+	})
+
+	var goodRan int
+	good := func(txn *lmdb.Txn) error {
+		goodRan++
+		return nil
+	}
+	bad := func(txn *lmdb.Txn) error {
+		return errBad
+	}
+
+	calls := []*batchCall{
+		{op: good, errc: make(chan error, 1)},
+		{op: bad, errc: make(chan error, 1)},
+		{op: good, errc: make(chan error, 1)},
+	}
+
+	b.commit(calls)
+
+	if err := <-calls[0].errc; err != nil {
+		t.Fatalf("call 0: expected success once re-run individually, got %v", err)
+	}
+	if err := <-calls[1].errc; err != errBad {
+		t.Fatalf("call 1 (the failing op): expected %v, got %v", errBad, err)
+	}
+	if err := <-calls[2].errc; err != nil {
+		t.Fatalf("call 2: expected success once re-run individually, got %v", err)
+	}
+
+	// the shared batch transaction, plus one retry transaction per
+	// surviving op (calls 0 and 2); the failing op is not retried.
+	if commits != 3 {
+		t.Fatalf("expected 3 underlying transactions (1 batch + 2 re-runs), got %d", commits)
+	}
+	if goodRan != 3 {
+		t.Fatalf("expected the two good ops to run 3 times total (once batched, once each re-run), got %d", goodRan)
+	}
+}
+
+func TestBatchedEnvCommitWholeTxnFailure(t *testing.T) {
+	errResize := errors.New("resize retries exhausted")
+	b := newTestBatchedEnv(func(op lmdb.TxnOp) error {
+		// fails before op ever runs, as happens when the retry chain gives
+		// up before invoking fn.
+		return errResize
+	})
+
+	calls := []*batchCall{
+		{op: func(txn *lmdb.Txn) error { return nil }, errc: make(chan error, 1)},
+		{op: func(txn *lmdb.Txn) error { return nil }, errc: make(chan error, 1)},
+	}
+
+	b.commit(calls)
+
+	for i, call := range calls {
+		if err := <-call.errc; err != errResize {
+			t.Fatalf("call %d: expected every caller to see %v, got %v", i, errResize, err)
+		}
+	}
+}
+
+func TestBatchedEnvSubmitAfterClose(t *testing.T) {
+	b := NewBatchedEnv(&Env{}, BatchOptions{})
+	b.updateFunc = func(op lmdb.TxnOp) error { return op(nil) }
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("second Close: expected nil, got %v", err)
+	}
+
+	if err := b.Update(func(txn *lmdb.Txn) error { return nil }); err != ErrBatchClosed {
+		t.Fatalf("Update after Close: expected %v, got %v", ErrBatchClosed, err)
+	}
+}
@@ -0,0 +1,145 @@
+package lmdbsync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+// ErrTxnCanceled is returned by a Context-aware transaction method when ctx
+// becomes done after the transaction has begun but before op runs.  If ctx
+// is already done before the transaction begins, ctx.Err() is returned
+// instead.
+var ErrTxnCanceled = errors.New("lmdbsync: transaction canceled")
+
+// lockPollInterval is the polling interval used while waiting on txnlock in
+// a Context-aware method, so that ctx cancellation can be noticed promptly
+// without the native select support sync.RWMutex lacks.
+const lockPollInterval = time.Millisecond
+
+// ViewContext is like View but returns ctx.Err() if ctx is done before a
+// read transaction can begin or while retries are being attempted.
+func (r *Env) ViewContext(ctx context.Context, op lmdb.TxnOp) error {
+	return r.runRetryContext(ctx, true, func() error {
+		return r.Env.View(ctxOp(ctx, op))
+	})
+}
+
+// UpdateContext is like Update but returns ctx.Err() if ctx is done before
+// a write transaction can begin or while retries are being attempted.
+func (r *Env) UpdateContext(ctx context.Context, op lmdb.TxnOp) error {
+	return r.runRetryContext(ctx, false, func() error {
+		return r.Env.Update(ctxOp(ctx, op))
+	})
+}
+
+// UpdateLockedContext is like UpdateLocked but returns ctx.Err() if ctx is
+// done before a write transaction can begin or while retries are being
+// attempted.
+func (r *Env) UpdateLockedContext(ctx context.Context, op lmdb.TxnOp) error {
+	return r.runRetryContext(ctx, false, func() error {
+		return r.Env.UpdateLocked(ctxOp(ctx, op))
+	})
+}
+
+// RunTxnContext is like RunTxn but returns ctx.Err() if ctx is done before
+// the transaction can begin or while retries are being attempted.
+func (r *Env) RunTxnContext(ctx context.Context, flags uint, op lmdb.TxnOp) error {
+	readonly := flags&lmdb.Readonly != 0
+	return r.runRetryContext(ctx, readonly, func() error {
+		return r.Env.RunTxn(flags, ctxOp(ctx, op))
+	})
+}
+
+// ctxOp wraps op so that a transaction which has not yet started running op
+// aborts immediately with ErrTxnCanceled once ctx is done, instead of
+// running op only to have its result discarded later.
+//
+// op runs synchronously, on the same goroutine lmdb-go requires: a write
+// Txn (and any TxnOp using it) must only ever be touched from the
+// goroutine that created it, so ctxOp cannot preempt op mid-flight by
+// racing it from another goroutine without risking undefined behavior in
+// the underlying C transaction.  A caller that needs a request-scoped
+// deadline to kill a stuck op must have op itself observe ctx (e.g. check
+// ctx.Err() between steps, or plumb ctx into whatever op blocks on) rather
+// than relying on ctxOp to preempt it from outside.
+func ctxOp(ctx context.Context, op lmdb.TxnOp) lmdb.TxnOp {
+	return func(txn *lmdb.Txn) error {
+		if err := ctx.Err(); err != nil {
+			return ErrTxnCanceled
+		}
+		return op(txn)
+	}
+}
+
+// runRetryContext is runRetry with two additional cancellation points: it
+// will not begin another attempt once ctx is done, and it does not sleep
+// through DelayRepeatResize when ctx is already canceled.
+func (r *Env) runRetryContext(ctx context.Context, readonly bool, fn func() error) error {
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := r.runContext(ctx, readonly, fn)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		retry, delay, outErr := r.dispatch(ctx, i, err)
+		if !retry {
+			return outErr
+		}
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// runContext is run with support for abandoning the wait for txnlock when
+// ctx is done.
+func (r *Env) runContext(ctx context.Context, readonly bool, fn func() error) error {
+	exclusive := r.noLock && !readonly
+	if err := r.lockContext(ctx, !exclusive); err != nil {
+		return err
+	}
+	defer r.unlockContext(!exclusive)
+
+	return fn()
+}
+
+// lockContext acquires r.txnlock, as an RLock if shared is true or a Lock
+// otherwise, polling so that ctx cancellation is noticed while waiting.
+func (r *Env) lockContext(ctx context.Context, shared bool) error {
+	for {
+		var ok bool
+		if shared {
+			ok = r.txnlock.TryRLock()
+		} else {
+			ok = r.txnlock.TryLock()
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func (r *Env) unlockContext(shared bool) {
+	if shared {
+		r.txnlock.RUnlock()
+	} else {
+		r.txnlock.Unlock()
+	}
+}
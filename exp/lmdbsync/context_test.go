@@ -0,0 +1,151 @@
+package lmdbsync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+func TestLockContextAcquiresWhenFree(t *testing.T) {
+	env := &Env{}
+
+	if err := env.lockContext(context.Background(), true); err != nil {
+		t.Fatalf("lockContext(shared): unexpected error: %v", err)
+	}
+	env.unlockContext(true)
+
+	if err := env.lockContext(context.Background(), false); err != nil {
+		t.Fatalf("lockContext(exclusive): unexpected error: %v", err)
+	}
+	env.unlockContext(false)
+}
+
+func TestLockContextRespectsCancellation(t *testing.T) {
+	env := &Env{}
+
+	// Hold the exclusive lock on the main goroutine so lockContext has no
+	// choice but to keep polling TryLock/TryRLock until ctx is done.
+	env.txnlock.Lock()
+	defer env.txnlock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := env.lockContext(ctx, false)
+	elapsed := time.Since(start)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("lockContext took %v to notice cancellation, want well under 1s", elapsed)
+	}
+}
+
+func TestLockContextRespectsCancellationShared(t *testing.T) {
+	env := &Env{}
+
+	// An exclusive holder blocks a shared (RLock) waiter too.
+	env.txnlock.Lock()
+	defer env.txnlock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := env.lockContext(ctx, true); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestCtxOpSkipsCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ran bool
+	op := ctxOp(ctx, func(txn *lmdb.Txn) error {
+		ran = true
+		return nil
+	})
+
+	if err := op(nil); err != ErrTxnCanceled {
+		t.Fatalf("expected ErrTxnCanceled, got %v", err)
+	}
+	if ran {
+		t.Fatalf("expected op not to run once ctx was already canceled")
+	}
+}
+
+func TestCtxOpRunsUnderLiveContext(t *testing.T) {
+	wantErr := errors.New("op error")
+	op := ctxOp(context.Background(), func(txn *lmdb.Txn) error {
+		return wantErr
+	})
+
+	if err := op(nil); err != wantErr {
+		t.Fatalf("expected op's own error to pass through, got %v", err)
+	}
+}
+
+func TestRunRetryContextAbandonsDelayOnCancellation(t *testing.T) {
+	env := &Env{}
+	env.SetHandlers(HandlerFunc(func(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+		// always willing to retry, after a delay far longer than the test
+		// should ever have to wait out.
+		return true, time.Hour, err
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts int
+	fn := func() error {
+		attempts++
+		return errors.New("boom")
+	}
+
+	// Cancel shortly after the first attempt has moved on to waiting out
+	// the handler's delay, rather than from inside fn, so this exercises
+	// runRetryContext abandoning the delay wait rather than the unrelated
+	// "ctx already done when fn returned" path.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	err := env.runRetryContext(ctx, true, fn)
+	elapsed := time.Since(start)
+
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("runRetryContext took %v to abandon its retry delay, want well under 1s", elapsed)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected exactly 1 attempt before the retry delay was abandoned, got %d", attempts)
+	}
+}
+
+func TestRunRetryContextStopsImmediatelyIfAlreadyCanceled(t *testing.T) {
+	env := &Env{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ran bool
+	err := env.runRetryContext(ctx, true, func() error {
+		ran = true
+		return nil
+	})
+
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if ran {
+		t.Fatalf("expected runRetryContext not to attempt fn with an already-canceled ctx")
+	}
+}
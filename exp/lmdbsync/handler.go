@@ -0,0 +1,175 @@
+package lmdbsync
+
+import (
+	"context"
+	"time"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+// Handler decides how runRetry responds to the error returned by a
+// transaction attempt: whether to retry it, how long to wait before doing
+// so, and (if it declines) what error the caller should see instead.
+//
+// attempt is the zero-based number of the attempt that just failed with
+// err.  A Handler that doesn't recognize err should return false and pass
+// err through unchanged so that later handlers in the chain get a chance
+// to handle it.
+type Handler interface {
+	HandleTxnErr(ctx context.Context, env *Env, attempt int, err error) (retry bool, delay time.Duration, outErr error)
+}
+
+// HandlerFunc adapts a plain function to the Handler interface.
+type HandlerFunc func(ctx context.Context, env *Env, attempt int, err error) (retry bool, delay time.Duration, outErr error)
+
+// HandleTxnErr calls f.
+func (f HandlerFunc) HandleTxnErr(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+	return f(ctx, env, attempt, err)
+}
+
+// DefaultHandlers is the handler chain used by an Env whose SetHandlers has
+// never been called.  It provides transparent MapResized and MapFull
+// handling with no idempotent-retry or logging behavior.
+var DefaultHandlers = []Handler{
+	MapResizedHandler{},
+	MapFullHandler{},
+}
+
+// SetHandlers replaces the Env's handler chain.  Handlers are tried in
+// order for each transaction error; the first one to report retry=true
+// wins and the rest of the chain is skipped for that attempt.  Calling
+// SetHandlers with no arguments restores DefaultHandlers.
+func (r *Env) SetHandlers(handlers ...Handler) {
+	r.handlers = handlers
+}
+
+func (r *Env) getHandlers() []Handler {
+	if r.handlers != nil {
+		return r.handlers
+	}
+	return DefaultHandlers
+}
+
+// MapResizedHandler retries a transaction that returned lmdb.MapResized,
+// adopting the new map size first.  It is part of DefaultHandlers.
+type MapResizedHandler struct{}
+
+// HandleTxnErr implements Handler.
+func (MapResizedHandler) HandleTxnErr(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+	if !lmdb.IsMapResized(err) {
+		return false, 0, err
+	}
+
+	maxRetry := env.getRetryResize()
+	if maxRetry <= 0 || maxRetry < attempt {
+		return false, 0, err
+	}
+
+	var delay time.Duration
+	if attempt > 0 {
+		delay = env.getDelayRepeatResize(attempt)
+	}
+
+	// When process-shared resize coordination is enabled, adopt the exact
+	// size recorded by whichever process grew the map; otherwise ask LMDB
+	// to read the size from its own meta page.
+	size, sizeErr := env.sharedMapSize()
+	if sizeErr != nil {
+		return false, 0, sizeErr
+	}
+
+	if setErr := env.setMapSize(size, delay); setErr != nil {
+		return false, 0, setErr
+	}
+	return true, 0, nil
+}
+
+// MapFullHandler retries a transaction that returned lmdb.MapFull, growing
+// the map first using the Env's GrowthFunc.  It is part of DefaultHandlers.
+type MapFullHandler struct{}
+
+// HandleTxnErr implements Handler.
+func (MapFullHandler) HandleTxnErr(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+	if !lmdb.IsMapFull(err) {
+		return false, 0, err
+	}
+
+	// A negative RetryMapFull/DefaultRetryMapFull means retry indefinitely;
+	// only a zero limit disables retrying, and only a positive limit caps
+	// the attempt count.
+	maxRetry := env.getRetryMapFull()
+	if maxRetry == 0 || (maxRetry > 0 && maxRetry <= attempt) {
+		return false, 0, err
+	}
+
+	if growErr := env.growMapSize(attempt); growErr != nil {
+		return false, 0, growErr
+	}
+	return true, 0, nil
+}
+
+// DefaultTxnRetry is the default TxnRetryHandler.MaxRetry.
+var DefaultTxnRetry = 3
+
+type idempotentKey struct{}
+
+// WithIdempotent marks ctx so that TxnRetryHandler will retry the
+// transaction it is passed to on lmdb.KeyExist or lmdb.TxnFull.  Only use
+// it with an op that is safe to run to completion more than once; those
+// errors are otherwise surfaced to the caller unretried because retrying
+// them is unsafe in general.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	v, _ := ctx.Value(idempotentKey{}).(bool)
+	return v
+}
+
+// TxnRetryHandler retries a transaction on lmdb.KeyExist or lmdb.TxnFull, a
+// bounded number of times, but only when ctx has been marked with
+// WithIdempotent.  It is opt-in per call and not part of DefaultHandlers.
+type TxnRetryHandler struct {
+	// MaxRetry is the maximum number of retries attempted. Zero uses
+	// DefaultTxnRetry.
+	MaxRetry int
+}
+
+// HandleTxnErr implements Handler.
+func (h TxnRetryHandler) HandleTxnErr(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+	if err != lmdb.KeyExist && err != lmdb.TxnFull {
+		return false, 0, err
+	}
+	if !isIdempotent(ctx) {
+		return false, 0, err
+	}
+
+	maxRetry := h.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = DefaultTxnRetry
+	}
+	if attempt >= maxRetry {
+		return false, 0, err
+	}
+	return true, 0, nil
+}
+
+// LogHandler logs every transaction error dispatched to the handler chain
+// and always declines to retry, deferring the decision to the handlers
+// after it in the chain.  Place it first in the chain passed to
+// SetHandlers so that it observes every attempt regardless of which
+// handler ultimately handles it.
+type LogHandler struct {
+	// Log is called with the failed attempt number and the error it
+	// failed with.  A nil Log makes LogHandler a no-op.
+	Log func(attempt int, err error)
+}
+
+// HandleTxnErr implements Handler.
+func (h LogHandler) HandleTxnErr(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+	if h.Log != nil {
+		h.Log(attempt, err)
+	}
+	return false, 0, err
+}
@@ -0,0 +1,196 @@
+package lmdbsync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+func TestDispatchFirstRetryingHandlerWins(t *testing.T) {
+	env := &Env{}
+	var called []string
+
+	env.SetHandlers(
+		HandlerFunc(func(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+			called = append(called, "decline")
+			return false, 0, err
+		}),
+		HandlerFunc(func(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+			called = append(called, "retry")
+			return true, 3 * time.Second, err
+		}),
+		HandlerFunc(func(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+			called = append(called, "unreached")
+			return true, 0, err
+		}),
+	)
+
+	origErr := errors.New("boom")
+	retry, delay, outErr := env.dispatch(context.Background(), 0, origErr)
+
+	if !retry {
+		t.Fatalf("expected retry=true from the second handler")
+	}
+	if delay != 3*time.Second {
+		t.Fatalf("expected the retrying handler's delay to be used, got %v", delay)
+	}
+	if outErr != origErr {
+		t.Fatalf("expected the retrying handler's error, got %v", outErr)
+	}
+	if got := []string{"decline", "retry"}; !equalStrings(called, got) {
+		t.Fatalf("expected handlers %v to run in order and stop at the first retry, got %v", got, called)
+	}
+}
+
+func TestDispatchThreadsDecliningHandlerError(t *testing.T) {
+	env := &Env{}
+	wrapped := errors.New("wrapped")
+
+	env.SetHandlers(
+		HandlerFunc(func(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+			return false, 0, wrapped
+		}),
+		HandlerFunc(func(ctx context.Context, env *Env, attempt int, err error) (bool, time.Duration, error) {
+			if err != wrapped {
+				t.Fatalf("expected this handler to see the previous handler's wrapped error, got %v", err)
+			}
+			return false, 0, err
+		}),
+	)
+
+	_, _, outErr := env.dispatch(context.Background(), 0, errors.New("original"))
+	if outErr != wrapped {
+		t.Fatalf("expected dispatch to surface the last declining handler's error, got %v", outErr)
+	}
+}
+
+func TestDispatchDefaultHandlersPassUnrecognizedErrThrough(t *testing.T) {
+	env := &Env{}
+	origErr := errors.New("boom")
+
+	retry, _, outErr := env.dispatch(context.Background(), 0, origErr)
+	if retry {
+		t.Fatalf("expected retry=false with DefaultHandlers and an unrecognized error")
+	}
+	if outErr != origErr {
+		t.Fatalf("expected the original error, got %v", outErr)
+	}
+}
+
+func TestDefaultGrowthFuncCapsStep(t *testing.T) {
+	cases := []struct {
+		current int64
+		want    int64
+	}{
+		{current: 1 << 20, want: 1 << 21},                  // doubles when small
+		{current: 2 << 30, want: 2<<30 + mapGrowthStepCap},  // capped once doubling exceeds the step
+		{current: 100 << 30, want: 100<<30 + mapGrowthStepCap}, // still capped
+	}
+
+	for _, c := range cases {
+		got := DefaultGrowthFunc(c.current, 0)
+		if got != c.want {
+			t.Errorf("DefaultGrowthFunc(%d, 0) = %d, want %d", c.current, got, c.want)
+		}
+	}
+}
+
+func TestMapFullHandlerIgnoresOtherErrors(t *testing.T) {
+	env := &Env{}
+	err := errors.New("not a map full error")
+
+	retry, _, outErr := MapFullHandler{}.HandleTxnErr(context.Background(), env, 0, err)
+	if retry {
+		t.Fatalf("expected MapFullHandler to decline a non-MapFull error")
+	}
+	if outErr != err {
+		t.Fatalf("expected the original error to pass through unchanged, got %v", outErr)
+	}
+}
+
+func TestMapFullHandlerExhaustsRetries(t *testing.T) {
+	retryMapFull := 2
+	env := &Env{RetryMapFull: &retryMapFull}
+
+	retry, _, outErr := MapFullHandler{}.HandleTxnErr(context.Background(), env, 3, lmdb.MapFull)
+	if retry {
+		t.Fatalf("expected MapFullHandler to decline once attempt exceeds RetryMapFull")
+	}
+	if outErr != lmdb.MapFull {
+		t.Fatalf("expected lmdb.MapFull to pass through, got %v", outErr)
+	}
+}
+
+func TestMapFullHandlerDefaultZeroDisablesRetry(t *testing.T) {
+	orig := DefaultRetryMapFull
+	DefaultRetryMapFull = 0
+	defer func() { DefaultRetryMapFull = orig }()
+
+	env := &Env{}
+	retry, _, outErr := MapFullHandler{}.HandleTxnErr(context.Background(), env, 0, lmdb.MapFull)
+	if retry {
+		t.Fatalf("expected DefaultRetryMapFull=0 to disable retrying entirely")
+	}
+	if outErr != lmdb.MapFull {
+		t.Fatalf("expected lmdb.MapFull to pass through, got %v", outErr)
+	}
+}
+
+func TestMapFullHandlerInstanceZeroDisablesRetry(t *testing.T) {
+	// Unlike DefaultRetryMapFull, an Env's own RetryMapFull must be able to
+	// disable retrying without touching the package-level default: a nil
+	// pointer inherits the default, but a pointer to zero means "disabled"
+	// for this Env specifically.
+	zero := 0
+	env := &Env{RetryMapFull: &zero}
+
+	retry, _, outErr := MapFullHandler{}.HandleTxnErr(context.Background(), env, 0, lmdb.MapFull)
+	if retry {
+		t.Fatalf("expected RetryMapFull pointing at 0 to disable retrying for this Env")
+	}
+	if outErr != lmdb.MapFull {
+		t.Fatalf("expected lmdb.MapFull to pass through, got %v", outErr)
+	}
+}
+
+func TestMapResizedHandlerIgnoresOtherErrors(t *testing.T) {
+	env := &Env{}
+	err := errors.New("not a map resized error")
+
+	retry, _, outErr := MapResizedHandler{}.HandleTxnErr(context.Background(), env, 0, err)
+	if retry {
+		t.Fatalf("expected MapResizedHandler to decline a non-MapResized error")
+	}
+	if outErr != err {
+		t.Fatalf("expected the original error to pass through unchanged, got %v", outErr)
+	}
+}
+
+func TestTxnRetryHandlerRequiresIdempotentContext(t *testing.T) {
+	env := &Env{}
+
+	retry, _, _ := TxnRetryHandler{}.HandleTxnErr(context.Background(), env, 0, lmdb.KeyExist)
+	if retry {
+		t.Fatalf("expected TxnRetryHandler to decline without WithIdempotent")
+	}
+
+	retry, _, _ = TxnRetryHandler{}.HandleTxnErr(WithIdempotent(context.Background()), env, 0, lmdb.KeyExist)
+	if !retry {
+		t.Fatalf("expected TxnRetryHandler to retry lmdb.KeyExist under WithIdempotent")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
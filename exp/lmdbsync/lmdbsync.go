@@ -21,6 +21,24 @@ processes accessing the database file.  And, a large memory map will not affect
 disk usage on operating systems that support sparse files (e.g. Linux, not OS
 X).
 
+Growing the map
+
+The Env type also intercepts lmdb.MapFull returned from write transactions
+and grows the map automatically before retrying, using GrowthFunc to compute
+the new size.  This lets long-running writers avoid hand-tuned initial map
+sizes; the default doubles the map up to a fixed step.
+
+EnableProcessSharedResize opts an Env into coordinating that growth across
+cooperating processes sharing the same data file, using a companion
+lockfile so that every process adopts the exact size chosen by whichever
+process grew the map, rather than racing on SetMapSize(0).
+
+All of the above is implemented as a chain of Handler values consulted by
+runRetry.  SetHandlers lets callers replace that chain to compose the
+built-in MapResizedHandler and MapFullHandler with their own, e.g. a
+TxnRetryHandler or a LogHandler for observability, without forking the
+package.
+
 NoLock
 
 The lmdb.NoLock flag performs all transaction synchronization with Go
@@ -30,6 +48,7 @@ provides.
 package lmdbsync
 
 import (
+	"context"
 	"os"
 	"sync"
 	"syscall"
@@ -50,6 +69,28 @@ var DefaultRetryResize = 2
 // Env will stop attempting to run it and return MapResize to the caller.
 var DefaultDelayRepeatResize = time.Millisecond
 
+// The default number of times to retry a transaction that is returning
+// repeatedly MapFull, growing the map size before each retry.
+//
+// If DefaultRetryMapFull is less than zero the transaction will be retried
+// indefinitely.
+var DefaultRetryMapFull = 2
+
+// DefaultGrowthFunc is the default Env.GrowthFunc.  It doubles the current
+// map size until doing so would grow the map by more than mapGrowthStepCap,
+// after which it grows the map by that fixed step instead.
+func DefaultGrowthFunc(current int64, attempt int) int64 {
+	grown := current * 2
+	if grown-current > mapGrowthStepCap {
+		grown = current + mapGrowthStepCap
+	}
+	return grown
+}
+
+// mapGrowthStepCap bounds the size of a single growth step taken by
+// DefaultGrowthFunc.
+const mapGrowthStepCap = 1 << 30 // 1GiB
+
 // Env wraps an *lmdb.Env, excepting the same methods, but provides transaction
 // management for advanced usage of LMDB.  Transactions run by Env handle
 // lmdb.MapResized error transparently through additional synchronization.
@@ -70,8 +111,28 @@ type Env struct {
 	RetryResize int
 	// DelayRepeateResize overrides DefaultDelayRetryResize for the Env.
 	DelayRepeatResize func(retry int) time.Duration
-	noLock            bool
-	txnlock           sync.RWMutex
+
+	// RetryMapFull overrides DefaultRetryMapFull for the Env.  A nil
+	// RetryMapFull inherits DefaultRetryMapFull; to disable MapFull
+	// retrying for this Env specifically, point it at a zero int rather
+	// than leaving it nil.
+	RetryMapFull *int
+	// GrowthFunc overrides DefaultGrowthFunc for the Env.  It computes the
+	// map size to adopt, given the current size and the retry attempt
+	// number, after a write transaction returns lmdb.MapFull.
+	GrowthFunc func(current int64, attempt int) int64
+	// MaxMapSize caps the size computed by GrowthFunc.  A value <= 0 means
+	// no cap is applied.
+	MaxMapSize int64
+
+	noLock  bool
+	txnlock sync.RWMutex
+
+	flockFile          *os.File
+	flockRetryInterval time.Duration
+	flockMaxWait       time.Duration
+
+	handlers []Handler
 }
 
 // NewEnv returns an newly allocated Env that wraps env.  If env is nil then
@@ -165,7 +226,11 @@ func (r *Env) setMapSize(size int64, delay time.Duration) error {
 		// begin while waiting.
 		time.Sleep(delay)
 	}
-	err := r.Env.SetMapSize(0)
+	// A size of zero tells LMDB to adopt whatever size is recorded in the
+	// environment's meta page.  When process-shared resize coordination is
+	// enabled callers pass the exact size recorded by the writer instead,
+	// avoiding a race where two processes observe different sizes there.
+	err := r.Env.SetMapSize(size)
 	r.txnlock.Unlock()
 	return err
 }
@@ -222,14 +287,37 @@ func (r *Env) UpdateLocked(op lmdb.TxnOp) error {
 }
 
 func (r *Env) runRetry(readonly bool, fn func() error) error {
-	var err error
+	ctx := context.Background()
 	for i := 0; ; i++ {
-		err = r.run(readonly, fn)
-		if !r.retryResized(i, err) {
-			return err
+		err := r.run(readonly, fn)
+		if err == nil {
+			return nil
+		}
+
+		retry, delay, outErr := r.dispatch(ctx, i, err)
+		if !retry {
+			return outErr
+		}
+		if delay > 0 {
+			time.Sleep(delay)
 		}
 	}
-	return err
+}
+
+// dispatch runs err through the Env's handler chain (see SetHandlers),
+// returning whether the caller should retry the transaction, how long to
+// wait before doing so, and the error to surface if it should not.
+func (r *Env) dispatch(ctx context.Context, attempt int, err error) (retry bool, delay time.Duration, outErr error) {
+	outErr = err
+	for _, h := range r.getHandlers() {
+		var retry bool
+		var delay time.Duration
+		retry, delay, outErr = h.HandleTxnErr(ctx, r, attempt, outErr)
+		if retry {
+			return true, delay, outErr
+		}
+	}
+	return false, 0, outErr
 }
 
 func (r *Env) run(readonly bool, fn func() error) error {
@@ -260,29 +348,67 @@ func (r *Env) getDelayRepeatResize(i int) time.Duration {
 	return DefaultDelayRepeatResize
 }
 
-func (r *Env) retryResized(i int, err error) bool {
-	if !lmdb.IsMapResized(err) {
-		return false
+// sharedMapSize returns the map size recorded by whichever process last
+// grew the map through EnableProcessSharedResize, or 0 if process-shared
+// resize coordination is not enabled (in which case the caller should ask
+// LMDB to adopt the size from its own meta page instead).
+func (r *Env) sharedMapSize() (int64, error) {
+	if r.flockFile == nil {
+		return 0, nil
 	}
 
-	// fail the transaction with MapResized error when too many attempts have
-	// been made.
-	maxRetry := r.getRetryResize()
-	if maxRetry <= 0 {
-		return false
+	if err := r.flock(false); err != nil {
+		return 0, err
 	}
-	if maxRetry < i {
-		return false
+	defer r.funlock()
+
+	return r.readSharedMapSize()
+}
+
+func (r *Env) getRetryMapFull() int {
+	if r.RetryMapFull != nil {
+		return *r.RetryMapFull
 	}
+	return DefaultRetryMapFull
+}
 
-	var delay time.Duration
-	if i > 0 {
-		delay = r.getDelayRepeatResize()
+func (r *Env) getGrowthFunc() func(current int64, attempt int) int64 {
+	if r.GrowthFunc != nil {
+		return r.GrowthFunc
 	}
+	return DefaultGrowthFunc
+}
 
-	err = r.setMapSize(0, delay)
+// growMapSize computes a new map size from the Env's GrowthFunc and adopts
+// it on the underlying lmdb.Env, holding txnlock for the duration so that
+// no transaction is running concurrently with the resize.
+func (r *Env) growMapSize(attempt int) error {
+	if r.flockFile != nil {
+		if err := r.flock(true); err != nil {
+			return err
+		}
+		defer r.funlock()
+	}
+
+	r.txnlock.Lock()
+	defer r.txnlock.Unlock()
+
+	info, err := r.Env.Info()
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	newSize := r.getGrowthFunc()(info.MapSize, attempt)
+	if max := r.MaxMapSize; max > 0 && newSize > max {
+		newSize = max
+	}
+
+	if err := r.Env.SetMapSize(newSize); err != nil {
+		return err
 	}
-	return true
+
+	if r.flockFile != nil {
+		return r.writeSharedMapSize(newSize)
+	}
+	return nil
 }
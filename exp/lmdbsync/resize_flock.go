@@ -0,0 +1,126 @@
+package lmdbsync
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// DefaultFlockRetryInterval is the default polling interval used while
+// waiting to acquire the companion lockfile opened by
+// EnableProcessSharedResize, analogous to bbolt's flockRetryTimeout.
+var DefaultFlockRetryInterval = 50 * time.Millisecond
+
+// DefaultFlockMaxWait is the default amount of time to wait for the
+// companion lockfile before giving up.  A value <= 0 means wait
+// indefinitely.
+var DefaultFlockMaxWait time.Duration
+
+// EnableProcessSharedResize opens (creating if necessary) a companion file
+// at lockPath and uses advisory flock locks on it to serialize SetMapSize
+// across cooperating processes sharing the same LMDB data file.
+//
+// Once enabled, a writer that discovers lmdb.MapFull takes an exclusive
+// lock on the companion file, grows the map, records the new size in the
+// file, and releases the lock.  A process that instead observes
+// lmdb.MapResized takes a shared lock, reads the recorded size, and adopts
+// that exact value with SetMapSize rather than racing on SetMapSize(0).
+//
+// lockPath should be a path next to the LMDB data file that every
+// cooperating process can read and write.
+func (r *Env) EnableProcessSharedResize(lockPath string) error {
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	r.txnlock.Lock()
+	r.flockFile = f
+	r.txnlock.Unlock()
+	return nil
+}
+
+// SetFlockRetry configures how the companion lockfile opened by
+// EnableProcessSharedResize is polled for: interval between attempts, and
+// the maximum total time to wait before giving up.  An interval <= 0 uses
+// DefaultFlockRetryInterval and a maxWait <= 0 uses DefaultFlockMaxWait,
+// which waits indefinitely unless it has been set to a positive value.
+func (r *Env) SetFlockRetry(interval, maxWait time.Duration) {
+	r.flockRetryInterval = interval
+	r.flockMaxWait = maxWait
+}
+
+func (r *Env) getFlockRetryInterval() time.Duration {
+	if r.flockRetryInterval > 0 {
+		return r.flockRetryInterval
+	}
+	return DefaultFlockRetryInterval
+}
+
+func (r *Env) getFlockMaxWait() time.Duration {
+	if r.flockMaxWait > 0 {
+		return r.flockMaxWait
+	}
+	return DefaultFlockMaxWait
+}
+
+// flock acquires an advisory lock on r.flockFile, exclusive or shared,
+// polling at getFlockRetryInterval until it succeeds or getFlockMaxWait
+// elapses.
+func (r *Env) flock(exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	var deadline time.Time
+	if maxWait := r.getFlockMaxWait(); maxWait > 0 {
+		deadline = time.Now().Add(maxWait)
+	}
+	retry := r.getFlockRetryInterval()
+
+	fd := int(r.flockFile.Fd())
+	for {
+		err := syscall.Flock(fd, how|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(retry)
+	}
+}
+
+func (r *Env) funlock() error {
+	return syscall.Flock(int(r.flockFile.Fd()), syscall.LOCK_UN)
+}
+
+// readSharedMapSize and writeSharedMapSize persist the agreed-upon map size
+// in the companion lockfile so that other processes can adopt the exact
+// value on SetMapSize instead of racing on SetMapSize(0).  Callers must
+// hold the appropriate flock while calling these.
+func (r *Env) readSharedMapSize() (int64, error) {
+	var buf [8]byte
+	_, err := r.flockFile.ReadAt(buf[:], 0)
+	if err == io.EOF {
+		// no writer has recorded a size yet
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[:])), nil
+}
+
+func (r *Env) writeSharedMapSize(size int64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(size))
+	_, err := r.flockFile.WriteAt(buf[:], 0)
+	return err
+}
@@ -0,0 +1,174 @@
+package lmdbsync
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestEnableProcessSharedResizeSetsFlockFile(t *testing.T) {
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, "lock")
+
+	env := &Env{}
+	if err := env.EnableProcessSharedResize(lockPath); err != nil {
+		t.Fatalf("EnableProcessSharedResize: unexpected error: %v", err)
+	}
+	defer env.flockFile.Close()
+
+	if env.flockFile == nil {
+		t.Fatalf("expected EnableProcessSharedResize to set r.flockFile")
+	}
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Fatalf("expected EnableProcessSharedResize to create %s: %v", lockPath, err)
+	}
+}
+
+func TestFlockExclusiveExcludesSharedAndExclusive(t *testing.T) {
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, "lock")
+
+	env := &Env{}
+	if err := env.EnableProcessSharedResize(lockPath); err != nil {
+		t.Fatalf("EnableProcessSharedResize: unexpected error: %v", err)
+	}
+	defer env.flockFile.Close()
+	env.SetFlockRetry(time.Millisecond, 20*time.Millisecond)
+
+	if err := env.flock(true); err != nil {
+		t.Fatalf("flock(exclusive): unexpected error: %v", err)
+	}
+	defer env.funlock()
+
+	// flock locks are scoped to the open file description, so a second,
+	// independent open of the same path is needed to observe contention
+	// the way a second cooperating process would.
+	other, err := os.OpenFile(lockPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("second open of lockfile: unexpected error: %v", err)
+	}
+	defer other.Close()
+
+	otherEnv := &Env{flockFile: other}
+	otherEnv.SetFlockRetry(time.Millisecond, 20*time.Millisecond)
+
+	if err := otherEnv.flock(false); err != syscall.EWOULDBLOCK {
+		t.Fatalf("expected a shared lock request to time out with EWOULDBLOCK while the exclusive lock is held, got %v", err)
+	}
+	if err := otherEnv.flock(true); err != syscall.EWOULDBLOCK {
+		t.Fatalf("expected an exclusive lock request to time out with EWOULDBLOCK while the exclusive lock is held, got %v", err)
+	}
+}
+
+func TestFlockUnlockReleasesForWaiters(t *testing.T) {
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, "lock")
+
+	env := &Env{}
+	if err := env.EnableProcessSharedResize(lockPath); err != nil {
+		t.Fatalf("EnableProcessSharedResize: unexpected error: %v", err)
+	}
+	defer env.flockFile.Close()
+
+	if err := env.flock(true); err != nil {
+		t.Fatalf("flock(exclusive): unexpected error: %v", err)
+	}
+	if err := env.funlock(); err != nil {
+		t.Fatalf("funlock: unexpected error: %v", err)
+	}
+
+	other, err := os.OpenFile(lockPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("second open of lockfile: unexpected error: %v", err)
+	}
+	defer other.Close()
+
+	otherEnv := &Env{flockFile: other}
+	otherEnv.SetFlockRetry(time.Millisecond, 20*time.Millisecond)
+	if err := otherEnv.flock(true); err != nil {
+		t.Fatalf("expected the exclusive lock to be available once the first holder released it, got %v", err)
+	}
+	otherEnv.funlock()
+}
+
+func TestFlockGivesUpAfterMaxWait(t *testing.T) {
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, "lock")
+
+	env := &Env{}
+	if err := env.EnableProcessSharedResize(lockPath); err != nil {
+		t.Fatalf("EnableProcessSharedResize: unexpected error: %v", err)
+	}
+	defer env.flockFile.Close()
+	if err := env.flock(true); err != nil {
+		t.Fatalf("flock(exclusive): unexpected error: %v", err)
+	}
+	defer env.funlock()
+
+	other, err := os.OpenFile(lockPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("second open of lockfile: unexpected error: %v", err)
+	}
+	defer other.Close()
+
+	otherEnv := &Env{flockFile: other}
+	otherEnv.SetFlockRetry(5*time.Millisecond, 30*time.Millisecond)
+
+	start := time.Now()
+	err = otherEnv.flock(true)
+	elapsed := time.Since(start)
+
+	if err != syscall.EWOULDBLOCK {
+		t.Fatalf("expected EWOULDBLOCK once getFlockMaxWait elapsed, got %v", err)
+	}
+	if elapsed < 30*time.Millisecond {
+		t.Fatalf("expected flock to poll for roughly getFlockMaxWait before giving up, gave up after %v", elapsed)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("flock took %v to give up, want well under 1s", elapsed)
+	}
+}
+
+func TestSharedMapSizeRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, "lock")
+
+	env := &Env{}
+	if err := env.EnableProcessSharedResize(lockPath); err != nil {
+		t.Fatalf("EnableProcessSharedResize: unexpected error: %v", err)
+	}
+	defer env.flockFile.Close()
+
+	if err := env.writeSharedMapSize(1 << 30); err != nil {
+		t.Fatalf("writeSharedMapSize: unexpected error: %v", err)
+	}
+
+	got, err := env.readSharedMapSize()
+	if err != nil {
+		t.Fatalf("readSharedMapSize: unexpected error: %v", err)
+	}
+	if got != 1<<30 {
+		t.Fatalf("readSharedMapSize: got %d, want %d", got, 1<<30)
+	}
+}
+
+func TestReadSharedMapSizeEmptyFileIsZero(t *testing.T) {
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, "lock")
+
+	env := &Env{}
+	if err := env.EnableProcessSharedResize(lockPath); err != nil {
+		t.Fatalf("EnableProcessSharedResize: unexpected error: %v", err)
+	}
+	defer env.flockFile.Close()
+
+	got, err := env.readSharedMapSize()
+	if err != nil {
+		t.Fatalf("readSharedMapSize on an empty file: unexpected error: %v", err)
+	}
+	if got != 0 {
+		t.Fatalf("readSharedMapSize on an empty file: got %d, want 0", got)
+	}
+}